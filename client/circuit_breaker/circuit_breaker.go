@@ -14,6 +14,7 @@
 package circuit_breaker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
@@ -39,31 +40,88 @@ const (
 	Yes
 )
 
+// TripStrategy defines how a CircuitBreaker decides to trip from the closed to the open state.
+type TripStrategy int
+
+const (
+	// ErrorRateStrategy trips the circuit breaker once the observed error rate over
+	// ErrorRateWindow breaches ErrorRateThresholdPct. This is the default strategy and
+	// preserves the original CircuitBreaker behavior.
+	ErrorRateStrategy TripStrategy = iota
+	// ConsecutiveFailuresStrategy trips the circuit breaker once ConsecutiveFailures
+	// requests have failed in a row, regardless of how many requests succeeded earlier
+	// in the window. Modeled after gobreaker's consecutive-failures counts.
+	ConsecutiveFailuresStrategy
+)
+
+// Counts holds the numbers of requests and their successes/failures observed by the
+// Tracker in the current state. It is passed to Settings.ReadyToTrip so that callers
+// can implement their own tripping predicate.
+type Counts struct {
+	Requests             uint32
+	TotalSuccesses       uint32
+	TotalFailures        uint32
+	ConsecutiveSuccesses uint32
+	ConsecutiveFailures  uint32
+}
+
+func (c *Counts) onRequest() {
+	c.Requests++
+}
+
+func (c *Counts) onSuccess() {
+	c.TotalSuccesses++
+	c.ConsecutiveSuccesses++
+	c.ConsecutiveFailures = 0
+}
+
+func (c *Counts) onFailure() {
+	c.TotalFailures++
+	c.ConsecutiveFailures++
+	c.ConsecutiveSuccesses = 0
+}
+
 // Settings describes configuration for Circuit Breaker
 type Settings struct {
-	// Defines the error rate threshold to trip the circuit breaker.
+	// Defines the strategy used to decide when to trip from closed to open.
+	// Defaults to ErrorRateStrategy.
+	TripStrategy TripStrategy
+	// Defines the error rate threshold to trip the circuit breaker. Only used by ErrorRateStrategy.
 	ErrorRateThresholdPct uint32
 	// Defines the average qps over the `error_rate_window` that must be met before evaluating the error rate threshold.
 	MinQPSForOpen uint32
 	// Defines how long to track errors before evaluating error_rate_threshold.
 	ErrorRateWindow time.Duration
+	// Defines how many sub-buckets ErrorRateWindow is split into. Each bucket covers
+	// ErrorRateWindow/ErrorRateWindowBuckets and is rolled off one at a time as it expires,
+	// so the error rate is evaluated continuously on every request instead of only once
+	// ErrorRateWindow elapses. Defaults to 1, which reproduces the original fixed-window behavior.
+	ErrorRateWindowBuckets uint32
+	// Defines how many consecutive failures trip the circuit breaker. Only used by
+	// ConsecutiveFailuresStrategy.
+	ConsecutiveFailures uint32
+	// ReadyToTrip, when set, overrides TripStrategy: it is called on every request admitted while
+	// the breaker is closed and past InitialDelay, with the Counts observed since the breaker
+	// entered the closed state (not limited to ErrorRateWindow).
+	// Returning true trips the circuit breaker to the open state.
+	ReadyToTrip func(counts Counts) bool
 	// Defines how long to wait after circuit breaker is open before go to half-open state to send a probe request.
 	CoolDownInterval time.Duration
 	// Defines how many subsequent requests to test after cooldown period before fully close the circuit.
 	HalfOpenSuccessCount uint32
-}
-
-// CircuitBreaker is a state machine to prevent sending requests that are likely to fail.
-type CircuitBreaker[T any] struct {
-	config *Settings
-	name   string
-
-	mutex sync.Mutex
-	state *State[T]
-
-	successCounter  prometheus.Counter
-	failureCounter  prometheus.Counter
-	fastFailCounter prometheus.Counter
+	// Defines the per-call timeout enforced by ExecuteContext and the half-open probe expiry.
+	// A call that does not return within CallTimeout is recorded as an Overloading failure.
+	// Zero disables the timeout.
+	CallTimeout time.Duration
+	// Called whenever the breaker transitions from one state to another, with the Counts
+	// accumulated during the state being left. Invoked outside of the Tracker's internal lock.
+	OnStateChange func(name string, from, to StateType, counts Counts)
+	// When set, overrides the Overloading value reported for a call: if the call returned a
+	// non-nil error for which IsSuccessful returns true, it is recorded as a success instead.
+	IsSuccessful func(err error) bool
+	// Defines how long to wait after the Tracker is created (or ChangeSettings re-arms it)
+	// before requests start counting toward the trip decision. Zero disables the warm-up period.
+	InitialDelay time.Duration
 }
 
 // StateType is a type that represents a state of CircuitBreaker.
@@ -92,181 +150,424 @@ func (s StateType) String() string {
 
 var replacer = strings.NewReplacer(" ", "_", "-", "_")
 
-// NewCircuitBreaker returns a new CircuitBreaker configured with the given Settings.
-func NewCircuitBreaker[T any](name string, st Settings) *CircuitBreaker[T] {
-	cb := new(CircuitBreaker[T])
-	cb.name = name
-	cb.config = &st
-	cb.state = cb.newState(time.Now(), StateClosed)
+// Generation identifies the Tracker state that observed a particular OnRequest call. Pass it
+// back to OnSuccess/OnFailure to report the outcome of that request; if the Tracker has since
+// moved on to a new state, the outcome is silently ignored, matching Execute's existing
+// "the state moved forward so we don't need to update the counts" behavior.
+type Generation[T any] struct {
+	state *State[T]
+	// admittedDuringWarmUp records whether OnRequest let this request through while the Tracker
+	// was still inside its InitialDelay warm-up window. It is decided once, at admission time,
+	// and honored as-is by OnSuccess/OnFailure however late the result arrives, so a slow call
+	// that straddles the end of the warm-up window is still excluded consistently rather than
+	// being counted because isWarmingUp happened to return false by the time the result showed
+	// up.
+	admittedDuringWarmUp bool
+}
+
+// Tracker drives the CircuitBreaker state machine independently of how a request's
+// success/failure signal is obtained. Execute and friends are a thin wrapper around it for the
+// common case of a single synchronous call; integrations where the signal is decoupled from a
+// single function call (e.g. a streaming RPC that is acked requests later, or a batched
+// keyspace request that fans out to multiple regions) can call OnRequest/OnSuccess/OnFailure
+// directly instead.
+type Tracker[T any] struct {
+	config *Settings
+	name   string
+
+	mutex       sync.Mutex
+	state       *State[T]
+	activatedAt time.Time
+
+	successCounter  prometheus.Counter
+	failureCounter  prometheus.Counter
+	fastFailCounter prometheus.Counter
+}
+
+// NewTracker returns a new Tracker configured with the given Settings.
+func NewTracker[T any](name string, st Settings) *Tracker[T] {
+	t := new(Tracker[T])
+	t.name = name
+	t.config = &st
+	t.activatedAt = time.Now()
+	t.state = t.newState(time.Now(), StateClosed)
 
 	metricName := replacer.Replace(name)
-	cb.successCounter = m.CircuitBreakerCounters.WithLabelValues(metricName, "success")
-	cb.failureCounter = m.CircuitBreakerCounters.WithLabelValues(metricName, "failure")
-	cb.fastFailCounter = m.CircuitBreakerCounters.WithLabelValues(metricName, "fast_fail")
-	return cb
+	t.successCounter = m.CircuitBreakerCounters.WithLabelValues(metricName, "success")
+	t.failureCounter = m.CircuitBreakerCounters.WithLabelValues(metricName, "failure")
+	t.fastFailCounter = m.CircuitBreakerCounters.WithLabelValues(metricName, "fast_fail")
+	return t
 }
 
-// ChangeSettings changes the CircuitBreaker settings.
-// The changes will be reflected only in the next evaluation window.
-func (cb *CircuitBreaker[T]) ChangeSettings(apply func(config *Settings)) {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+// ChangeSettings changes the Tracker settings.
+// Most settings are read on every request and take effect from the next one. The bucket layout
+// derived from ErrorRateWindow and ErrorRateWindowBuckets is only rebuilt when the Tracker next
+// enters the closed state; the current closed state keeps rolling its existing buckets.
+// ChangeSettings also re-arms Settings.InitialDelay, giving the Tracker a fresh warm-up period
+// under the new configuration.
+func (t *Tracker[T]) ChangeSettings(apply func(config *Settings)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
-	apply(cb.config)
+	apply(t.config)
+	t.activatedAt = time.Now()
 }
 
-// Execute calls the given function if the CircuitBreaker is closed and returns the result of execution.
-// Execute returns an error instantly if the CircuitBreaker is open.
-// https://github.com/tikv/rfcs/blob/master/text/0115-circuit-breaker.md
-func (cb *CircuitBreaker[T]) Execute(call func() (T, error, Overloading)) (T, error) {
-	result, err := cb.ExecuteAny(func() (interface{}, error, Overloading) {
-		res, err, open := call()
-		return res, err, open
-	})
-	if result == nil {
-		// this branch is required to support primitive types like int, which can't be nil
-		var defaultValue T
-		return defaultValue, err
-	} else {
-		return result.(T), err
+// isWarmingUp reports whether the Tracker is still inside its InitialDelay warm-up window,
+// during which all requests pass through without affecting the trip decision.
+func (t *Tracker[T]) isWarmingUp(now time.Time) bool {
+	return t.config.InitialDelay > 0 && now.Before(t.activatedAt.Add(t.config.InitialDelay))
+}
+
+// OnRequest evaluates the state machine for a new request and returns the Generation it was
+// admitted under. It returns ErrOpenState instead if the request should be fast-failed because
+// the breaker is open or half-open with no more probe slots available.
+func (t *Tracker[T]) OnRequest() (Generation[T], error) {
+	now := time.Now()
+	t.mutex.Lock()
+	prevState := t.state
+	// decided once here, under the mutex, and carried on the Generation so OnSuccess/OnFailure
+	// honor the warm-up status this request was actually admitted under instead of re-deriving
+	// it from the wall clock whenever the result happens to arrive
+	warmingUp := t.isWarmingUp(now)
+	state, err := t.state.onRequest(t, now, warmingUp)
+	t.state = state
+	// snapshot the hook pointer while still holding the mutex: ChangeSettings can replace it
+	// concurrently, and reading t.config after unlocking would race with that write.
+	onStateChange := t.config.OnStateChange
+	t.mutex.Unlock()
+
+	// fire outside of the mutex so OnStateChange can safely re-enter the breaker
+	if state.stateType != prevState.stateType && onStateChange != nil {
+		onStateChange(t.name, prevState.stateType, state.stateType, prevState.counts)
 	}
+	return Generation[T]{state: state, admittedDuringWarmUp: warmingUp}, err
 }
 
-// ExecuteAny is similar to Execute, but allows the caller to return any type of result.
-func (cb *CircuitBreaker[T]) ExecuteAny(call func() (interface{}, error, Overloading)) (interface{}, error) {
-	state, err := cb.onRequest()
-	if err != nil {
-		var defaultValue interface{}
-		return defaultValue, err
+// OnSuccess reports that the request admitted under gen succeeded.
+func (t *Tracker[T]) OnSuccess(gen Generation[T]) {
+	t.onResult(gen, No)
+}
+
+// OnFailure reports that the request admitted under gen failed or was overloaded.
+func (t *Tracker[T]) OnFailure(gen Generation[T]) {
+	t.onResult(gen, Yes)
+}
+
+func (t *Tracker[T]) onResult(gen Generation[T], open Overloading) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if gen.admittedDuringWarmUp {
+		// this request was let through during InitialDelay; honor that regardless of whether
+		// the warm-up window has since elapsed
+		return
 	}
+	if t.state == gen.state {
+		gen.state.onResult(open)
+	} // else the state moved forward so we don't need to update the counts
+}
 
-	defer func() {
-		e := recover()
-		if e != nil {
-			cb.onResult(state, Yes)
-			panic(e)
-		}
-	}()
+// State returns the current state of the Tracker, the Counts for the active evaluation window,
+// and the time at which the current closed-state window or open-state cooldown ends. The
+// half-open state has no fixed end time and reports the zero time.Time.
+//
+// For a closed ErrorRateStrategy breaker, Requests/TotalSuccesses/TotalFailures reflect only
+// the sliding ErrorRateWindow that the trip decision is actually evaluated against, not the
+// lifetime of the state, so they won't diverge from what's about to trip the breaker.
+// ConsecutiveSuccesses/ConsecutiveFailures always describe the current streak since the last
+// state transition, which has no separate "window" to speak of.
+func (t *Tracker[T]) State() (StateType, Counts, time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
-	result, err, open := call()
-	cb.onResult(state, open)
-	return result, err
+	return t.state.stateType, t.state.currentCounts(), t.state.end
 }
 
-func (cb *CircuitBreaker[T]) onRequest() (*State[T], error) {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+// setState forcibly moves the Tracker to stateType, firing OnStateChange outside of the mutex
+// like a regular transition. It backs ForceOpen, ForceClose and Reset.
+func (t *Tracker[T]) setState(stateType StateType) {
+	now := time.Now()
+	t.mutex.Lock()
+	prevState := t.state
+	t.state = t.newState(now, stateType)
+	// snapshotted under the mutex for the same reason as in OnRequest: t.config.OnStateChange
+	// can be reassigned concurrently by ChangeSettings.
+	onStateChange := t.config.OnStateChange
+	t.mutex.Unlock()
 
-	state, err := cb.state.onRequest(cb)
-	cb.state = state
-	return state, err
+	if prevState.stateType != stateType && onStateChange != nil {
+		onStateChange(t.name, prevState.stateType, stateType, prevState.counts)
+	}
 }
 
-func (cb *CircuitBreaker[T]) onResult(state *State[T], open Overloading) {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+// ForceOpen immediately trips the Tracker to the open state for a full CoolDownInterval,
+// bypassing the configured trip strategy. It lets an operator pin a breaker open during
+// incident response, e.g. while a TiKV store is known to be under a disruptive upgrade.
+func (t *Tracker[T]) ForceOpen() {
+	t.setState(StateOpen)
+}
 
-	if cb.state == state {
-		state.onResult(open)
-	} // else the state moved forward so we don't need to update the counts
+// ForceClose immediately moves the Tracker to a fresh closed state, discarding any
+// in-progress window or cooldown. It lets an operator clear a stuck half-open state or
+// reopen a breaker previously pinned with ForceOpen without waiting for the state machine
+// to recover on its own.
+func (t *Tracker[T]) ForceClose() {
+	t.setState(StateClosed)
+}
+
+// Reset returns the Tracker to the same state as a freshly constructed one: a new closed
+// state with all counters cleared and, if InitialDelay is configured, a new warm-up period.
+func (t *Tracker[T]) Reset() {
+	t.mutex.Lock()
+	t.activatedAt = time.Now()
+	t.mutex.Unlock()
+
+	t.setState(StateClosed)
+}
+
+// callSettings is the subset of Settings that CircuitBreaker needs to read outside of the
+// locked onRequest/onResult path, e.g. before starting call() or while deciding how to classify
+// its outcome. snapshotCallSettings reads them together under the mutex so callers never race
+// with a concurrent ChangeSettings the way a bare t.config.CallTimeout read would.
+type callSettings struct {
+	callTimeout  time.Duration
+	isSuccessful func(err error) bool
+}
+
+// snapshotCallSettings returns the CallTimeout and IsSuccessful settings currently configured.
+func (t *Tracker[T]) snapshotCallSettings() callSettings {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return callSettings{
+		callTimeout:  t.config.CallTimeout,
+		isSuccessful: t.config.IsSuccessful,
+	}
+}
+
+// trip moves the Tracker to the open state and returns the error signaling that the
+// just-evaluated request should fail fast.
+func (t *Tracker[T]) trip(now time.Time, reason string) (*State[T], error) {
+	log.Error("Circuit breaker tripped. Starting to fail all requests",
+		zap.String("name", t.name),
+		zap.String("reason", reason),
+		zap.String("config", fmt.Sprintf("%+v", t.config)))
+	t.fastFailCounter.Inc()
+	return t.newState(now, StateOpen), ErrOpenState
+}
+
+// bucket is one sub-window of the sliding error rate window. Closed state splits
+// ErrorRateWindow into Settings.ErrorRateWindowBuckets buckets of equal duration and rolls
+// them forward on every request evaluation so the error rate is assessed continuously.
+type bucket struct {
+	end          time.Time
+	successCount uint32
+	failureCount uint32
 }
 
 type State[T any] struct {
 	stateType StateType
-	cb        *CircuitBreaker[T]
+	t         *Tracker[T]
 	end       time.Time
 
+	// counts accumulates for the lifetime of the state, regardless of bucket rollover, so that
+	// ConsecutiveFailuresStrategy and custom ReadyToTrip hooks see the true consecutive streak.
+	counts Counts
+
+	// buckets is only populated for StateClosed; it implements the sliding error rate window.
+	buckets        []bucket
+	bucketDuration time.Duration
+
 	pendingCount uint32
 	successCount uint32
 	failureCount uint32
+
+	// probeDeadlines records, in admission order, the time by which each half-open probe must
+	// report a result before onRequest treats it as expired and trips the breaker back to open.
+	// Only populated for StateHalfOpen, and only when Settings.CallTimeout is non-zero.
+	probeDeadlines []time.Time
 }
 
 // newState creates a new State with the given configuration and reset all success/failure counters.
-func (cb *CircuitBreaker[T]) newState(now time.Time, stateType StateType) *State[T] {
+func (t *Tracker[T]) newState(now time.Time, stateType StateType) *State[T] {
 	var end time.Time
 	var pendingCount uint32
+	var buckets []bucket
+	var bucketDuration time.Duration
+	var probeDeadlines []time.Time
 	switch stateType {
 	case StateClosed:
-		end = now.Add(cb.config.ErrorRateWindow)
+		numBuckets := t.config.ErrorRateWindowBuckets
+		if numBuckets == 0 {
+			numBuckets = 1
+		}
+		bucketDuration = t.config.ErrorRateWindow / time.Duration(numBuckets)
+		// ErrorRateWindow is only meaningful for ErrorRateStrategy; ConsecutiveFailuresStrategy
+		// and a custom ReadyToTrip have no reason to set it, and a non-positive bucketDuration
+		// would otherwise never advance past an already-expired bucket in rollBuckets.
+		if bucketDuration > 0 {
+			buckets = make([]bucket, numBuckets)
+			for i := range buckets {
+				buckets[i].end = now.Add(bucketDuration * time.Duration(i+1))
+			}
+			end = buckets[len(buckets)-1].end
+		}
 	case StateOpen:
-		end = now.Add(cb.config.CoolDownInterval)
+		end = now.Add(t.config.CoolDownInterval)
 	case StateHalfOpen:
 		// we transition to HalfOpen state on the first request after the cooldown period,
 		//so we start with 1 pending request
 		pendingCount = 1
+		if t.config.CallTimeout > 0 {
+			probeDeadlines = append(probeDeadlines, now.Add(t.config.CallTimeout))
+		}
 	default:
 		panic("unknown state")
 	}
 	return &State[T]{
-		cb:           cb,
-		stateType:    stateType,
-		pendingCount: pendingCount,
-		end:          end,
+		t:              t,
+		stateType:      stateType,
+		pendingCount:   pendingCount,
+		end:            end,
+		buckets:        buckets,
+		bucketDuration: bucketDuration,
+		probeDeadlines: probeDeadlines,
+	}
+}
+
+// rollBuckets drops buckets that have fully expired and appends fresh, empty ones in their
+// place so the sliding window always covers (now-ErrorRateWindow, now].
+func (s *State[T]) rollBuckets(now time.Time) {
+	if s.bucketDuration <= 0 {
+		// no sliding window configured (e.g. ErrorRateWindow is 0), nothing to roll
+		return
+	}
+	for len(s.buckets) > 0 && s.buckets[0].end.Before(now) {
+		s.buckets = append(s.buckets[1:], bucket{end: s.end.Add(s.bucketDuration)})
+		s.end = s.buckets[len(s.buckets)-1].end
 	}
 }
 
+// windowCounts returns the total success/failure counts currently held across all buckets of
+// the sliding error rate window.
+func (s *State[T]) windowCounts() (successCount, failureCount uint32) {
+	for _, b := range s.buckets {
+		successCount += b.successCount
+		failureCount += b.failureCount
+	}
+	return
+}
+
+// currentCounts returns the Counts to report for introspection. When a sliding error rate
+// window is in effect, Requests/TotalSuccesses/TotalFailures are overridden with the
+// window-scoped totals the trip decision is evaluated against, instead of the lifetime-since-
+// transition totals in s.counts, so the two can't disagree about whether the breaker is about
+// to trip. The consecutive streak fields always come from s.counts since they have no
+// window-scoped equivalent.
+func (s *State[T]) currentCounts() Counts {
+	counts := s.counts
+	if len(s.buckets) > 0 {
+		successCount, failureCount := s.windowCounts()
+		counts.TotalSuccesses = successCount
+		counts.TotalFailures = failureCount
+		counts.Requests = successCount + failureCount
+	}
+	return counts
+}
+
 // onRequest transitions the state to the next state based on the current state and the previous requests results
 // All state transitions happens at the request evaluation time only
 // The implementation represents a state machine effectively
-func (s *State[T]) onRequest(cb *CircuitBreaker[T]) (*State[T], error) {
-	var now = time.Now()
+func (s *State[T]) onRequest(t *Tracker[T], now time.Time, warmingUp bool) (*State[T], error) {
 	switch s.stateType {
 	case StateClosed:
-		if s.end.Before(now) {
-			// ErrorRateWindow is over, let's evaluate the error rate
-			total := s.failureCount + s.successCount
-			observedErrorRatePct := s.failureCount * 100 / total
-			if s.cb.config.ErrorRateThresholdPct > 0 && total >= uint32(s.cb.config.ErrorRateWindow.Seconds())*s.cb.config.MinQPSForOpen && observedErrorRatePct >= s.cb.config.ErrorRateThresholdPct {
+		if warmingUp {
+			// still inside InitialDelay: let the request through without evaluating a trip
+			return s, nil
+		}
+		// a single bucket is a fixed window: its error rate is evaluated only once it has elapsed,
+		// from the counts it held before rollBuckets starts a fresh window
+		fixedWindow := len(s.buckets) == 1
+		fixedWindowDue := fixedWindow && s.end.Before(now)
+		fixedSuccessCount, fixedFailureCount := s.windowCounts()
+		s.rollBuckets(now)
+
+		if t.config.ReadyToTrip != nil {
+			if t.config.ReadyToTrip(s.counts) {
+				return t.trip(now, "custom ReadyToTrip predicate")
+			}
+			return s, nil
+		}
+
+		switch t.config.TripStrategy {
+		case ConsecutiveFailuresStrategy:
+			if t.config.ConsecutiveFailures > 0 && s.counts.ConsecutiveFailures >= t.config.ConsecutiveFailures {
+				return t.trip(now, fmt.Sprintf("%d consecutive failures", s.counts.ConsecutiveFailures))
+			}
+			return s, nil
+		default: // ErrorRateStrategy
+			successCount, failureCount := s.windowCounts()
+			if fixedWindow {
+				if !fixedWindowDue {
+					// continue in closed state till ErrorRateWindow is over
+					return s, nil
+				}
+				successCount, failureCount = fixedSuccessCount, fixedFailureCount
+			}
+			total := successCount + failureCount
+			if total == 0 {
+				// no requests observed in the current window yet, nothing to evaluate
+				return s, nil
+			}
+			observedErrorRatePct := failureCount * 100 / total
+			if t.config.ErrorRateThresholdPct > 0 && total >= uint32(t.config.ErrorRateWindow.Seconds())*t.config.MinQPSForOpen && observedErrorRatePct >= t.config.ErrorRateThresholdPct {
 				// the error threshold is breached, let's move to open state and start failing all requests
-				log.Error("Circuit breaker tripped. Starting to fail all requests",
-					zap.String("name", cb.name),
-					zap.Uint32("observedErrorRatePct", observedErrorRatePct),
-					zap.String("config", fmt.Sprintf("%+v", cb.config)))
-				cb.fastFailCounter.Inc()
-				return cb.newState(now, StateOpen), ErrOpenState
-			} else {
-				// the error threshold is not breached or there were not enough requests to evaluate it,
-				// continue in the closed state and allow all requests
-				return cb.newState(now, StateClosed), nil
+				return t.trip(now, fmt.Sprintf("observed error rate %d%% over the last %s", observedErrorRatePct, t.config.ErrorRateWindow))
 			}
-		} else {
-			// continue in closed state till ErrorRateWindow is over
 			return s, nil
 		}
 	case StateOpen:
 		if s.end.Before(now) {
 			// CoolDownInterval is over, it is time to transition to half-open state
 			log.Info("Circuit breaker cooldown period is over. Transitioning to half-open state to test the service",
-				zap.String("name", cb.name),
-				zap.String("config", fmt.Sprintf("%+v", cb.config)))
-			return cb.newState(now, StateHalfOpen), nil
+				zap.String("name", t.name),
+				zap.String("config", fmt.Sprintf("%+v", t.config)))
+			return t.newState(now, StateHalfOpen), nil
 		} else {
 			// continue in the open state till CoolDownInterval is over
-			cb.fastFailCounter.Inc()
+			t.fastFailCounter.Inc()
 			return s, ErrOpenState
 		}
 	case StateHalfOpen:
-		// do we need some expire time here in case of one of pending requests is stuck forever?
+		// the oldest still-outstanding probe never reported a result within CallTimeout: a lost
+		// ack, a crashed consumer, or any other caller that admitted a probe via OnRequest and
+		// never called OnSuccess/OnFailure back would otherwise wedge the breaker in half-open
+		// forever. This is evaluated lazily here, the same way StateOpen's cooldown is, so it
+		// applies regardless of whether the caller goes through Execute, ExecuteContext, or
+		// drives the Tracker directly.
+		resolved := s.successCount + s.failureCount
+		if resolved < uint32(len(s.probeDeadlines)) && s.probeDeadlines[resolved].Before(now) {
+			return t.trip(now, "a half-open probe timed out waiting for a result")
+		}
 		if s.failureCount > 0 {
 			// there were some failures during half-open state, let's go back to open state to wait a bit longer
-			log.Error("Circuit breaker goes from half-open to open again as errors persist and continue to fail all requests",
-				zap.String("name", cb.name),
-				zap.String("config", fmt.Sprintf("%+v", cb.config)))
-			cb.fastFailCounter.Inc()
-			return cb.newState(now, StateOpen), ErrOpenState
-		} else if s.successCount == s.cb.config.HalfOpenSuccessCount {
+			return t.trip(now, "errors persisted while half-open")
+		} else if s.successCount == s.t.config.HalfOpenSuccessCount {
 			// all probe requests are succeeded, we can move to closed state and allow all requests
 			log.Info("Circuit breaker is closed. Start allowing all requests",
-				zap.String("name", cb.name),
-				zap.String("config", fmt.Sprintf("%+v", cb.config)))
-			return cb.newState(now, StateClosed), nil
-		} else if s.pendingCount < s.cb.config.HalfOpenSuccessCount {
+				zap.String("name", t.name),
+				zap.String("config", fmt.Sprintf("%+v", t.config)))
+			return t.newState(now, StateClosed), nil
+		} else if s.pendingCount < s.t.config.HalfOpenSuccessCount {
 			// allow more probe requests and continue in half-open state
 			s.pendingCount++
+			if t.config.CallTimeout > 0 {
+				s.probeDeadlines = append(s.probeDeadlines, now.Add(t.config.CallTimeout))
+			}
 			return s, nil
 		} else {
 			// continue in half-open state till all probe requests are done and fail all other requests for now
-			cb.fastFailCounter.Inc()
+			t.fastFailCounter.Inc()
 			return s, ErrOpenState
 		}
 	default:
@@ -278,11 +579,247 @@ func (s *State[T]) onResult(open Overloading) {
 	switch open {
 	case No:
 		s.successCount++
-		s.cb.successCounter.Inc()
+		s.counts.onSuccess()
+		if len(s.buckets) > 0 {
+			s.buckets[len(s.buckets)-1].successCount++
+		}
+		s.t.successCounter.Inc()
 	case Yes:
 		s.failureCount++
-		s.cb.fastFailCounter.Inc()
+		s.counts.onFailure()
+		if len(s.buckets) > 0 {
+			s.buckets[len(s.buckets)-1].failureCount++
+		}
+		s.t.fastFailCounter.Inc()
 	default:
 		panic("unknown state")
 	}
+	s.counts.onRequest()
+}
+
+// Breaker is the subset of CircuitBreaker's admin surface that does not depend on the call's
+// result type. It lets AdminHandler, a pd-ctl command, or any other admin surface inspect and
+// override any registered breaker by name (via Lookup/List) without needing to know the generic
+// type parameter it was constructed with.
+type Breaker interface {
+	Name() string
+	State() (StateType, Counts, time.Time)
+	ForceOpen()
+	ForceClose()
+	Reset()
+}
+
+// registry holds every CircuitBreaker registered via NewCircuitBreaker, keyed by name, so
+// Lookup/List can find one without the caller holding on to its own reference.
+var registry sync.Map // name string -> Breaker
+
+// Lookup returns the registered breaker with the given name, for a pd-ctl command or admin HTTP
+// handler to inspect or override. ok is false if no breaker with that name has been registered.
+func Lookup(name string) (breaker Breaker, ok bool) {
+	v, ok := registry.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return v.(Breaker), true
+}
+
+// List returns the names of all currently registered breakers, e.g. for a pd-ctl command to
+// enumerate what's available before operating on one by name.
+func List() []string {
+	var names []string
+	registry.Range(func(key, _ interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	return names
+}
+
+// CircuitBreaker is a state machine to prevent sending requests that are likely to fail.
+type CircuitBreaker[T any] struct {
+	tracker *Tracker[T]
+}
+
+// NewCircuitBreaker returns a new CircuitBreaker configured with the given Settings, and
+// registers it under name so it can be found later via Lookup, e.g. by a pd-ctl command or an
+// admin HTTP handler that wants to inspect or override a breaker without holding a reference of
+// its own. A second NewCircuitBreaker call with the same name replaces the earlier registration.
+func NewCircuitBreaker[T any](name string, st Settings) *CircuitBreaker[T] {
+	cb := &CircuitBreaker[T]{tracker: NewTracker[T](name, st)}
+	registry.Store(name, Breaker(cb))
+	return cb
+}
+
+// ChangeSettings changes the CircuitBreaker settings. As with Tracker.ChangeSettings, most
+// settings take effect from the next request, the ErrorRateWindow bucket layout only from the
+// next closed state, and Settings.InitialDelay is re-armed.
+func (cb *CircuitBreaker[T]) ChangeSettings(apply func(config *Settings)) {
+	cb.tracker.ChangeSettings(apply)
+}
+
+// Name returns the name the CircuitBreaker was constructed with, i.e. the key it is registered
+// under in Lookup/List.
+func (cb *CircuitBreaker[T]) Name() string {
+	return cb.tracker.name
+}
+
+// State returns the current state, the Counts for the active evaluation window, and the time
+// at which the current window or cooldown ends, for runtime introspection.
+func (cb *CircuitBreaker[T]) State() (StateType, Counts, time.Time) {
+	return cb.tracker.State()
+}
+
+// ForceOpen pins the CircuitBreaker open for a full CoolDownInterval, bypassing the
+// configured trip strategy. Intended for operator use, e.g. during a known TiKV upgrade.
+func (cb *CircuitBreaker[T]) ForceOpen() {
+	cb.tracker.ForceOpen()
+}
+
+// ForceClose immediately moves the CircuitBreaker to a fresh closed state. Intended for
+// operator use, e.g. to clear a stuck half-open state or lift a prior ForceOpen.
+func (cb *CircuitBreaker[T]) ForceClose() {
+	cb.tracker.ForceClose()
+}
+
+// Reset returns the CircuitBreaker to the same state as one freshly constructed with
+// NewCircuitBreaker: closed, with all counters cleared and InitialDelay re-armed.
+func (cb *CircuitBreaker[T]) Reset() {
+	cb.tracker.Reset()
+}
+
+// Execute calls the given function if the CircuitBreaker is closed and returns the result of execution.
+// Execute returns an error instantly if the CircuitBreaker is open.
+// https://github.com/tikv/rfcs/blob/master/text/0115-circuit-breaker.md
+func (cb *CircuitBreaker[T]) Execute(call func() (T, error, Overloading)) (T, error) {
+	result, err := cb.ExecuteAny(func() (interface{}, error, Overloading) {
+		res, err, open := call()
+		return res, err, open
+	})
+	if result == nil {
+		// this branch is required to support primitive types like int, which can't be nil
+		var defaultValue T
+		return defaultValue, err
+	} else {
+		return result.(T), err
+	}
+}
+
+// ExecuteAny is similar to Execute, but allows the caller to return any type of result.
+func (cb *CircuitBreaker[T]) ExecuteAny(call func() (interface{}, error, Overloading)) (interface{}, error) {
+	gen, err := cb.tracker.OnRequest()
+	if err != nil {
+		var defaultValue interface{}
+		return defaultValue, err
+	}
+
+	isSuccessful := cb.tracker.snapshotCallSettings().isSuccessful
+
+	defer func() {
+		e := recover()
+		if e != nil {
+			cb.tracker.OnFailure(gen)
+			panic(e)
+		}
+	}()
+
+	result, err, open := call()
+	if cb.isFailure(err, open, isSuccessful) {
+		cb.tracker.OnFailure(gen)
+	} else {
+		cb.tracker.OnSuccess(gen)
+	}
+	return result, err
+}
+
+// isFailure decides whether a call outcome should be recorded as a circuit breaker failure.
+// isSuccessful, a snapshot of Settings.IsSuccessful taken by the caller under the Tracker's
+// mutex, lets callers reclassify errors like a client-side cancellation as an expected,
+// non-failing outcome even though the call itself reported Overloading.
+func (cb *CircuitBreaker[T]) isFailure(err error, open Overloading, isSuccessful func(err error) bool) bool {
+	if open != Yes {
+		return false
+	}
+	if err != nil && isSuccessful != nil && isSuccessful(err) {
+		return false
+	}
+	return true
+}
+
+// ExecuteContext is similar to Execute, but takes a context.Context that is derived into a
+// cancelable child and passed through to call. The child context is cancelled as soon as
+// ExecuteContext returns, whether the call finished or ran longer than Settings.CallTimeout,
+// so that the caller's downstream RPC can abort quickly. If the CircuitBreaker is open, call
+// is not invoked at all and ExecuteContext returns ErrOpenState. A call that does
+// not return within CallTimeout is recorded as an Overloading failure (subject to
+// Settings.IsSuccessful, like any other failure) and ExecuteContext returns promptly without
+// waiting for it any longer. If instead ctx itself is cancelled or expires before the call
+// returns, that is not counted against the breaker, since it reflects the caller giving up
+// rather than anything the backend did.
+func (cb *CircuitBreaker[T]) ExecuteContext(ctx context.Context, call func(ctx context.Context) (T, error, Overloading)) (T, error) {
+	var defaultValue T
+
+	gen, err := cb.tracker.OnRequest()
+	if err != nil {
+		return defaultValue, err
+	}
+
+	settings := cb.tracker.snapshotCallSettings()
+
+	var callCtx context.Context
+	var cancel context.CancelFunc
+	if settings.callTimeout > 0 {
+		callCtx, cancel = context.WithTimeout(ctx, settings.callTimeout)
+	} else {
+		callCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	type callResult struct {
+		result   T
+		err      error
+		open     Overloading
+		panicVal interface{}
+	}
+	resultCh := make(chan callResult, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				resultCh <- callResult{panicVal: e}
+			}
+		}()
+		result, err, open := call(callCtx)
+		resultCh <- callResult{result: result, err: err, open: open}
+	}()
+
+	select {
+	case res := <-resultCh:
+		if res.panicVal != nil {
+			cb.tracker.OnFailure(gen)
+			panic(res.panicVal)
+		}
+		if cb.isFailure(res.err, res.open, settings.isSuccessful) {
+			cb.tracker.OnFailure(gen)
+		} else {
+			cb.tracker.OnSuccess(gen)
+		}
+		return res.result, res.err
+	case <-callCtx.Done():
+		// callCtx.Done can fire for two different reasons that must not be conflated: our own
+		// CallTimeout elapsing (the backend is slow and should count against the breaker), or
+		// the caller's own ctx being cancelled/expiring for reasons that have nothing to do
+		// with the backend (the caller gave up). Only the former is our failure to report; we
+		// still run it through isFailure so Settings.IsSuccessful gets a say either way.
+		callErr := callCtx.Err()
+		if ctx.Err() == nil {
+			if cb.isFailure(callErr, Yes, settings.isSuccessful) {
+				cb.tracker.OnFailure(gen)
+			} else {
+				cb.tracker.OnSuccess(gen)
+			}
+		}
+		// else: ctx itself was cancelled/expired, so the call was abandoned without ever
+		// learning whether the backend would have succeeded. Leave gen unresolved rather than
+		// reporting OnSuccess for a health check that never actually completed, the same as the
+		// "lost ack" case that CallTimeout's probeDeadlines expiry handles for half-open.
+		return defaultValue, callErr
+	}
 }
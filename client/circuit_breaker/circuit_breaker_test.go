@@ -0,0 +1,516 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package circuit_breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errFake = errors.New("fake error")
+
+func TestSlidingWindowRollover(t *testing.T) {
+	tr := NewTracker[int]("test-sliding-window", Settings{
+		ErrorRateWindow:        100 * time.Millisecond,
+		ErrorRateWindowBuckets: 4,
+	})
+
+	now := time.Now()
+	s := tr.newState(now, StateClosed)
+	if len(s.buckets) != 4 {
+		t.Fatalf("expected 4 buckets, got %d", len(s.buckets))
+	}
+
+	// record a failure; results always land in the newest (last) bucket
+	s.onResult(Yes)
+	if successCount, failureCount := s.windowCounts(); successCount != 0 || failureCount != 1 {
+		t.Fatalf("unexpected window counts after first failure: success=%d failure=%d", successCount, failureCount)
+	}
+
+	// advance past the whole window: every bucket, including the one holding the failure,
+	// should have rolled off by now
+	s.rollBuckets(now.Add(200 * time.Millisecond))
+	if successCount, failureCount := s.windowCounts(); successCount != 0 || failureCount != 0 {
+		t.Fatalf("expected the failure to roll off after the window elapsed, got success=%d failure=%d", successCount, failureCount)
+	}
+}
+
+func TestConsecutiveFailuresTrip(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-consecutive-failures", Settings{
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  3,
+		CoolDownInterval:     time.Minute,
+		HalfOpenSuccessCount: 1,
+	})
+	failingCall := func() (int, error, Overloading) { return 0, errFake, Yes }
+	succeedingCall := func() (int, error, Overloading) { return 1, nil, No }
+
+	// two failures in a row, not enough to trip yet
+	for i := 0; i < 2; i++ {
+		if _, err := cb.Execute(failingCall); !errors.Is(err, errFake) {
+			t.Fatalf("call %d: expected the injected error, got %v", i, err)
+		}
+	}
+
+	// a success resets the consecutive streak
+	if _, err := cb.Execute(succeedingCall); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+
+	// three failures in a row: the trip is only evaluated on the *next* request, same as the
+	// existing error-rate strategy, so it takes a fourth call to observe ErrOpenState
+	for i := 0; i < 3; i++ {
+		if _, err := cb.Execute(failingCall); !errors.Is(err, errFake) {
+			t.Fatalf("call %d: expected the injected error, got %v", i, err)
+		}
+	}
+	if _, err := cb.Execute(succeedingCall); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("expected the breaker to trip after three consecutive failures, got %v", err)
+	}
+	if state, _, _ := cb.State(); state != StateOpen {
+		t.Fatalf("expected state Open, got %v", state)
+	}
+}
+
+func TestExecuteContextTimeout(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-call-timeout", Settings{
+		CallTimeout:          10 * time.Millisecond,
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  1,
+		CoolDownInterval:     time.Minute,
+		HalfOpenSuccessCount: 1,
+	})
+
+	_, err := cb.ExecuteContext(context.Background(), func(ctx context.Context) (int, error, Overloading) {
+		<-ctx.Done()
+		return 0, ctx.Err(), Yes
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if _, counts, _ := cb.State(); counts.TotalFailures != 1 {
+		t.Fatalf("expected the timeout to be recorded as a failure, got %+v", counts)
+	}
+}
+
+func TestExecuteContextCallerCancellation(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-caller-cancel", Settings{
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  1,
+		CoolDownInterval:     time.Minute,
+		HalfOpenSuccessCount: 1,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := cb.ExecuteContext(ctx, func(ctx context.Context) (int, error, Overloading) {
+		<-ctx.Done()
+		return 0, ctx.Err(), Yes
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected Canceled, got %v", err)
+	}
+	if _, counts, _ := cb.State(); counts.TotalFailures != 0 {
+		t.Fatalf("expected a caller-side cancellation to not count as a breaker failure, got %+v", counts)
+	}
+}
+
+// TestExecuteContextCallerCancellationDuringHalfOpenProbe checks that a caller-side cancellation
+// of a half-open probe is left unresolved rather than recorded as a success: the probe never
+// actually learned whether the backend is healthy, so closing the breaker on it would defeat the
+// whole point of the half-open check.
+func TestExecuteContextCallerCancellationDuringHalfOpenProbe(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-caller-cancel-half-open-probe", Settings{
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  1,
+		CoolDownInterval:     10 * time.Millisecond,
+		HalfOpenSuccessCount: 1,
+	})
+
+	if _, err := cb.Execute(func() (int, error, Overloading) { return 0, errFake, Yes }); !errors.Is(err, errFake) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+	if _, err := cb.Execute(func() (int, error, Overloading) { return 0, nil, No }); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("expected the breaker to trip after the first failure, got %v", err)
+	}
+
+	time.Sleep(15 * time.Millisecond) // let CoolDownInterval elapse so the probe runs half-open
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := cb.ExecuteContext(ctx, func(ctx context.Context) (int, error, Overloading) {
+		<-ctx.Done()
+		return 0, ctx.Err(), Yes
+	}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected Canceled, got %v", err)
+	}
+
+	if state, counts, _ := cb.State(); state != StateHalfOpen || counts.TotalSuccesses != 0 {
+		t.Fatalf("expected the abandoned probe to stay unresolved in half-open, got state=%v counts=%+v", state, counts)
+	}
+}
+
+// TestTrackerDirectUsage drives the state machine through OnRequest/OnSuccess/OnFailure
+// directly, the way an async integration (e.g. a streaming RPC acked later) would, without
+// going through Execute/ExecuteAny at all.
+func TestTrackerDirectUsage(t *testing.T) {
+	tr := NewTracker[int]("test-tracker-direct", Settings{
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  2,
+		CoolDownInterval:     time.Minute,
+		HalfOpenSuccessCount: 1,
+	})
+
+	gen, err := tr.OnRequest()
+	if err != nil {
+		t.Fatalf("unexpected error on first request: %v", err)
+	}
+	tr.OnFailure(gen)
+
+	gen, err = tr.OnRequest()
+	if err != nil {
+		t.Fatalf("unexpected error on second request: %v", err)
+	}
+	tr.OnSuccess(gen)
+
+	if _, counts, _ := tr.State(); counts.ConsecutiveFailures != 0 {
+		t.Fatalf("expected OnSuccess to reset the consecutive streak, got %+v", counts)
+	}
+
+	gen, err = tr.OnRequest()
+	if err != nil {
+		t.Fatalf("unexpected error rebuilding the streak: %v", err)
+	}
+	tr.OnFailure(gen)
+	gen, err = tr.OnRequest()
+	if err != nil {
+		t.Fatalf("unexpected error rebuilding the streak: %v", err)
+	}
+	tr.OnFailure(gen)
+
+	if _, err := tr.OnRequest(); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("expected the tracker to trip after two consecutive failures, got %v", err)
+	}
+	if state, _, _ := tr.State(); state != StateOpen {
+		t.Fatalf("expected state Open, got %v", state)
+	}
+}
+
+// TestHalfOpenProbeTimeoutExpires checks that a half-open probe which never reports a result
+// (e.g. a caller driving the Tracker directly, like TestTrackerDirectUsage above, that loses
+// the ack) still gets the breaker moving again instead of wedging it in half-open forever.
+func TestHalfOpenProbeTimeoutExpires(t *testing.T) {
+	tr := NewTracker[int]("test-half-open-probe-timeout", Settings{
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  1,
+		CoolDownInterval:     10 * time.Millisecond,
+		HalfOpenSuccessCount: 1,
+		CallTimeout:          20 * time.Millisecond,
+	})
+
+	gen, err := tr.OnRequest()
+	if err != nil {
+		t.Fatalf("unexpected error on first request: %v", err)
+	}
+	tr.OnFailure(gen)
+
+	if _, err := tr.OnRequest(); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("expected the breaker to trip after the first failure, got %v", err)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+
+	// admits the first half-open probe, but it is abandoned here and never resolved via
+	// OnSuccess/OnFailure, simulating a lost ack on the direct-drive Tracker path
+	if _, err := tr.OnRequest(); err != nil {
+		t.Fatalf("expected the first half-open probe to be admitted, got %v", err)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if _, err := tr.OnRequest(); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("expected the expired probe to trip the breaker back to open, got %v", err)
+	}
+	if state, _, _ := tr.State(); state != StateOpen {
+		t.Fatalf("expected state Open after the probe expired, got %v", state)
+	}
+}
+
+// TestOnStateChangeHook checks that OnStateChange fires exactly once per transition, with the
+// right from/to states, and that it is safe to re-enter the breaker from within the hook
+// (i.e. it isn't called while the Tracker's mutex is held).
+func TestOnStateChangeHook(t *testing.T) {
+	type transition struct{ from, to StateType }
+	var mu sync.Mutex
+	var transitions []transition
+
+	var cb *CircuitBreaker[int]
+	cb = NewCircuitBreaker[int]("test-state-change", Settings{
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  1,
+		CoolDownInterval:     time.Minute,
+		HalfOpenSuccessCount: 1,
+		OnStateChange: func(name string, from, to StateType, counts Counts) {
+			mu.Lock()
+			transitions = append(transitions, transition{from, to})
+			mu.Unlock()
+			// must be safe to re-enter the breaker from within the hook
+			cb.State()
+		},
+	})
+
+	cb.Execute(func() (int, error, Overloading) { return 0, errFake, Yes }) // first failure, not tripped yet
+	if _, err := cb.Execute(func() (int, error, Overloading) { return 0, errFake, Yes }); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("expected the breaker to trip, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(transitions) != 1 || transitions[0].from != StateClosed || transitions[0].to != StateOpen {
+		t.Fatalf("expected a single closed->open transition, got %+v", transitions)
+	}
+}
+
+func TestIsSuccessfulHook(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-is-successful", Settings{
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  1,
+		CoolDownInterval:     time.Minute,
+		HalfOpenSuccessCount: 1,
+		IsSuccessful: func(err error) bool {
+			return errors.Is(err, context.Canceled)
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := cb.Execute(func() (int, error, Overloading) {
+			return 0, context.Canceled, Yes
+		}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("call %d: expected context.Canceled, got %v", i, err)
+		}
+	}
+
+	if state, counts, _ := cb.State(); state != StateClosed || counts.ConsecutiveFailures != 0 {
+		t.Fatalf("expected cancellations classified as successes to leave the breaker closed, got state=%v counts=%+v", state, counts)
+	}
+}
+
+func TestInitialDelay(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-initial-delay", Settings{
+		TripStrategy:         ConsecutiveFailuresStrategy,
+		ConsecutiveFailures:  1,
+		CoolDownInterval:     time.Minute,
+		HalfOpenSuccessCount: 1,
+		InitialDelay:         50 * time.Millisecond,
+	})
+	failingCall := func() (int, error, Overloading) { return 0, errFake, Yes }
+
+	// failures during the warm-up period pass through but must not affect the trip decision
+	for i := 0; i < 5; i++ {
+		if _, err := cb.Execute(failingCall); !errors.Is(err, errFake) {
+			t.Fatalf("call %d during warm-up: expected the injected error, got %v", i, err)
+		}
+	}
+	if state, counts, _ := cb.State(); state != StateClosed || counts.ConsecutiveFailures != 0 {
+		t.Fatalf("expected failures during InitialDelay to be uncounted, got state=%v counts=%+v", state, counts)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	// the first failure after warm-up is counted but, as usual, only trips on the next request
+	if _, err := cb.Execute(failingCall); !errors.Is(err, errFake) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+	if _, err := cb.Execute(failingCall); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("expected the breaker to trip once warm-up has ended, got %v", err)
+	}
+}
+
+// TestInitialDelayDecidedAtAdmission reproduces a request that is admitted while still inside
+// InitialDelay but whose outcome is reported only after the warm-up window has elapsed, e.g. a
+// slow call, or an async Tracker consumer that acks later, as in the direct-drive Tracker use
+// case. The warm-up exemption must be decided once at OnRequest time and honored at
+// OnFailure/OnSuccess, not re-derived from the wall clock when the result happens to arrive.
+func TestInitialDelayDecidedAtAdmission(t *testing.T) {
+	tr := NewTracker[int]("test-initial-delay-at-admission", Settings{
+		TripStrategy:        ConsecutiveFailuresStrategy,
+		ConsecutiveFailures: 1,
+		CoolDownInterval:    time.Minute,
+		InitialDelay:        30 * time.Millisecond,
+	})
+
+	gen, err := tr.OnRequest()
+	if err != nil {
+		t.Fatalf("expected the request to be admitted during warm-up, got %v", err)
+	}
+
+	// the result arrives after InitialDelay has elapsed
+	time.Sleep(40 * time.Millisecond)
+	tr.OnFailure(gen)
+
+	if _, counts, _ := tr.State(); counts.ConsecutiveFailures != 0 {
+		t.Fatalf("expected a result for a warm-up-admitted request to stay uncounted even once reported late, got counts=%+v", counts)
+	}
+}
+
+// TestStateIntrospection checks that State() reports the window-scoped counts the trip
+// decision is actually evaluated against, not lifetime-since-transition totals.
+func TestStateIntrospection(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-state-introspection", Settings{
+		ErrorRateThresholdPct:  50,
+		MinQPSForOpen:          1,
+		ErrorRateWindow:        time.Minute,
+		ErrorRateWindowBuckets: 2,
+		CoolDownInterval:       time.Minute,
+		HalfOpenSuccessCount:   1,
+	})
+
+	cb.Execute(func() (int, error, Overloading) { return 0, nil, No })
+	cb.Execute(func() (int, error, Overloading) { return 0, errFake, Yes })
+
+	state, counts, end := cb.State()
+	if state != StateClosed {
+		t.Fatalf("expected Closed, got %v", state)
+	}
+	if counts.TotalSuccesses != 1 || counts.TotalFailures != 1 {
+		t.Fatalf("expected window counts to reflect both calls, got %+v", counts)
+	}
+	if !end.After(time.Now()) {
+		t.Fatalf("expected a future window end, got %v", end)
+	}
+}
+
+func TestForceOpenCloseReset(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-force-controls", Settings{
+		ErrorRateThresholdPct: 50,
+		ErrorRateWindow:       time.Minute,
+		CoolDownInterval:      time.Minute,
+		HalfOpenSuccessCount:  1,
+	})
+
+	cb.ForceOpen()
+	if state, _, _ := cb.State(); state != StateOpen {
+		t.Fatalf("expected ForceOpen to open the breaker, got %v", state)
+	}
+	if _, err := cb.Execute(func() (int, error, Overloading) { return 0, nil, No }); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("expected calls to be fast-failed while forced open, got %v", err)
+	}
+
+	cb.ForceClose()
+	if state, _, _ := cb.State(); state != StateClosed {
+		t.Fatalf("expected ForceClose to close the breaker, got %v", state)
+	}
+	if _, err := cb.Execute(func() (int, error, Overloading) { return 0, nil, No }); err != nil {
+		t.Fatalf("expected calls to go through after ForceClose, got %v", err)
+	}
+
+	cb.Execute(func() (int, error, Overloading) { return 0, errFake, Yes })
+	cb.Reset()
+	if state, counts, _ := cb.State(); state != StateClosed || counts.TotalFailures != 0 {
+		t.Fatalf("expected Reset to clear counters, got state=%v counts=%+v", state, counts)
+	}
+}
+
+// TestRegistryLookup checks that NewCircuitBreaker registers itself under its name so a pd-ctl
+// command or admin HTTP handler can find and operate on it via Lookup/List without holding a
+// reference of its own.
+func TestRegistryLookup(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-registry-lookup", Settings{
+		CoolDownInterval: time.Minute,
+	})
+
+	breaker, ok := Lookup("test-registry-lookup")
+	if !ok {
+		t.Fatalf("expected the breaker to be registered under its name")
+	}
+	if breaker.Name() != "test-registry-lookup" {
+		t.Fatalf("expected Name() to round-trip, got %q", breaker.Name())
+	}
+
+	found := false
+	for _, name := range List() {
+		if name == "test-registry-lookup" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected List() to include the registered breaker, got %v", List())
+	}
+
+	// Breaker is the type-erased admin surface: operate on it without knowing it's a
+	// *CircuitBreaker[int], the way a pd-ctl command or admin HTTP handler would.
+	breaker.ForceOpen()
+	if state, _, _ := cb.State(); state != StateOpen {
+		t.Fatalf("expected ForceOpen via the registry to open the underlying breaker, got %v", state)
+	}
+
+	if _, ok := Lookup("no-such-breaker"); ok {
+		t.Fatalf("expected Lookup to report ok=false for an unregistered name")
+	}
+}
+
+// TestConcurrentChangeSettingsRace drives ChangeSettings concurrently with ExecuteContext calls
+// that read OnStateChange, IsSuccessful and CallTimeout outside of onRequest/onResult. It exists
+// to be run under `go test -race`: before these reads were snapshotted under the Tracker's
+// mutex, this reproduced a data race between ChangeSettings' write and Execute/ExecuteContext's
+// unlocked reads of the same Settings fields.
+func TestConcurrentChangeSettingsRace(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-concurrent-change-settings", Settings{
+		CallTimeout:  time.Millisecond,
+		IsSuccessful: func(err error) bool { return false },
+		OnStateChange: func(name string, from, to StateType, counts Counts) {
+		},
+	})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				cb.ChangeSettings(func(s *Settings) {
+					s.CallTimeout = time.Millisecond
+					s.IsSuccessful = func(err error) bool { return false }
+					s.OnStateChange = func(name string, from, to StateType, counts Counts) {}
+				})
+			}
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		_, _ = cb.ExecuteContext(ctx, func(ctx context.Context) (int, error, Overloading) {
+			return 0, nil, No
+		})
+		cancel()
+		_, _ = cb.Execute(func() (int, error, Overloading) { return 0, nil, No })
+	}
+
+	close(stop)
+	wg.Wait()
+}
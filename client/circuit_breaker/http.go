@@ -0,0 +1,103 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package circuit_breaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// breakerStateResponse is the JSON representation of a breaker's State(), returned by
+// AdminHandler's per-breaker routes.
+type breakerStateResponse struct {
+	Name   string    `json:"name"`
+	State  string    `json:"state"`
+	Counts Counts    `json:"counts"`
+	End    time.Time `json:"end,omitempty"`
+}
+
+// AdminHandler serves an HTTP admin API over the package-level breaker registry, so an embedding
+// binary (e.g. PD's HTTP server) can mount it under a path of its choosing and let an SRE inspect
+// or override any named breaker without restarting the client:
+//
+//	GET  /               the names of all registered breakers
+//	GET  /{name}         the state, Counts, and window/cooldown end time of the named breaker
+//	POST /{name}/open    ForceOpen the named breaker
+//	POST /{name}/close   ForceClose the named breaker
+//	POST /{name}/reset   Reset the named breaker
+//
+// Mount it with e.g. mux.Handle("/circuit-breakers/", http.StripPrefix("/circuit-breakers", circuit_breaker.AdminHandler())).
+func AdminHandler() http.Handler {
+	return http.HandlerFunc(serveAdmin)
+}
+
+func serveAdmin(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, List())
+		return
+	}
+
+	name, action, hasAction := strings.Cut(path, "/")
+	breaker, ok := Lookup(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("circuit breaker %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	if !hasAction {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, breakerStateToResponse(breaker))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch action {
+	case "open":
+		breaker.ForceOpen()
+	case "close":
+		breaker.ForceClose()
+	case "reset":
+		breaker.Reset()
+	default:
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, breakerStateToResponse(breaker))
+}
+
+func breakerStateToResponse(breaker Breaker) breakerStateResponse {
+	state, counts, end := breaker.State()
+	return breakerStateResponse{Name: breaker.Name(), State: state.String(), Counts: counts, End: end}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package circuit_breaker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminHandlerListAndState(t *testing.T) {
+	NewCircuitBreaker[int]("test-admin-http", Settings{
+		CoolDownInterval: time.Minute,
+	})
+	handler := AdminHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 listing breakers, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "test-admin-http") {
+		t.Fatalf("expected the list to include the registered breaker, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test-admin-http", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 reading state, got %d", rec.Code)
+	}
+	var resp breakerStateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Name != "test-admin-http" || resp.State != StateClosed.String() {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/no-such-breaker", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unregistered breaker, got %d", rec.Code)
+	}
+}
+
+func TestAdminHandlerForceControls(t *testing.T) {
+	cb := NewCircuitBreaker[int]("test-admin-http-controls", Settings{
+		CoolDownInterval: time.Minute,
+	})
+	handler := AdminHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test-admin-http-controls/open", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 forcing open, got %d", rec.Code)
+	}
+	if state, _, _ := cb.State(); state != StateOpen {
+		t.Fatalf("expected the breaker to be open after the open route, got %v", state)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test-admin-http-controls/close", nil))
+	if state, _, _ := cb.State(); state != StateClosed {
+		t.Fatalf("expected the breaker to be closed after the close route, got %v", state)
+	}
+
+	cb.Execute(func() (int, error, Overloading) { return 0, errFake, Yes })
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test-admin-http-controls/reset", nil))
+	if _, counts, _ := cb.State(); counts.TotalFailures != 0 {
+		t.Fatalf("expected the reset route to clear counters, got %+v", counts)
+	}
+
+	// a GET against an action route, and a POST against an unknown action, are both rejected
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test-admin-http-controls/open", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for a GET against an action route, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test-admin-http-controls/bogus", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown action, got %d", rec.Code)
+	}
+}